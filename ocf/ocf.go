@@ -0,0 +1,49 @@
+/*
+Package ocf implements the Avro Object Container File format on top of
+avro.Prepare: a 4-byte magic, a metadata map carrying the writer's schema
+and codec, a 16-byte sync marker, then a sequence of blocks, each a run
+of records optionally compressed and trailed by the sync marker again.
+
+See https://avro.apache.org/docs/current/spec.html#Object+Container+Files
+for the format this package implements.
+*/
+package ocf
+
+import "fmt"
+
+// magic is the 4-byte sequence every OCF file starts with: "Obj" followed
+// by the format version this package writes and reads.
+var magic = [4]byte{'O', 'b', 'j', 1}
+
+// syncSize is the length, in bytes, of the sync marker that separates
+// the header from the first block and trails every block after it.
+const syncSize = 16
+
+// Codec names the compression applied to a block's record bytes. These
+// are the values stored under the "avro.codec" metadata key.
+type Codec string
+
+const (
+	// Null applies no compression.
+	Null Codec = "null"
+	// Deflate compresses block bytes with compress/flate.
+	Deflate Codec = "deflate"
+	// Snappy compresses block bytes with the Snappy algorithm, including
+	// its trailing CRC32 checksum of the uncompressed bytes as required
+	// by the OCF spec.
+	Snappy Codec = "snappy"
+)
+
+const (
+	metaSchemaKey = "avro.schema"
+	metaCodecKey  = "avro.codec"
+)
+
+func (c Codec) validate() error {
+	switch c {
+	case Null, Deflate, Snappy:
+		return nil
+	default:
+		return fmt.Errorf("ocf: unknown codec %q", c)
+	}
+}
@@ -0,0 +1,291 @@
+package ocf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/golang/snappy"
+	"github.com/tunein/go-avro"
+)
+
+// Reader reads records from an Avro Object Container File, resolving the
+// file's writer schema against a caller-supplied reader schema exactly
+// once via avro.PrepareResolving, then decoding every block with the
+// resulting cached plan.
+type Reader struct {
+	r    *bufio.Reader
+	sync [syncSize]byte
+
+	writerSchema avro.Schema
+	schema       avro.Schema
+	metadata     map[string][]byte
+	codec        Codec
+	datum        *avro.SpecificDatumReader
+
+	blockLeft int64
+	blockDec  avro.Decoder
+	err       error
+}
+
+// NewReader parses the OCF header from r and prepares reader against the
+// file's embedded writer schema. Passing a nil reader schema uses the
+// writer schema for both sides, equivalent to avro.Prepare.
+func NewReader(r io.Reader, reader avro.Schema) (*Reader, error) {
+	or := &Reader{r: bufio.NewReader(r)}
+
+	var hdr [4]byte
+	if _, err := io.ReadFull(or.r, hdr[:]); err != nil {
+		return nil, fmt.Errorf("ocf: reading magic: %w", err)
+	}
+	if hdr != magic {
+		return nil, fmt.Errorf("ocf: bad magic %q, not an Avro object container file", hdr)
+	}
+
+	meta, err := readMetaMap(or.r)
+	if err != nil {
+		return nil, fmt.Errorf("ocf: reading metadata: %w", err)
+	}
+	or.metadata = meta
+
+	if _, err := io.ReadFull(or.r, or.sync[:]); err != nil {
+		return nil, fmt.Errorf("ocf: reading sync marker: %w", err)
+	}
+
+	rawSchema, ok := meta[metaSchemaKey]
+	if !ok {
+		return nil, fmt.Errorf("ocf: header metadata missing %q", metaSchemaKey)
+	}
+	writerSchema, err := avro.ParseSchema(string(rawSchema))
+	if err != nil {
+		return nil, fmt.Errorf("ocf: parsing writer schema: %w", err)
+	}
+	or.writerSchema = writerSchema
+
+	or.codec = Null
+	if rawCodec, ok := meta[metaCodecKey]; ok && len(rawCodec) > 0 {
+		or.codec = Codec(rawCodec)
+	}
+	if err := or.codec.validate(); err != nil {
+		return nil, err
+	}
+
+	if reader == nil {
+		reader = writerSchema
+	}
+	or.schema = avro.PrepareResolving(writerSchema, reader)
+	or.datum = avro.NewSpecificDatumReader()
+	or.datum.SetSchema(or.schema)
+
+	return or, nil
+}
+
+// Schema returns the resolving schema records are decoded against (the
+// file's writer schema resolved with the caller's reader schema).
+func (r *Reader) Schema() avro.Schema { return r.schema }
+
+// Metadata returns the raw header metadata map, including any
+// application-defined entries beyond avro.schema and avro.codec.
+func (r *Reader) Metadata() map[string][]byte { return r.metadata }
+
+// HasNext reports whether another record is available, advancing to the
+// next block (and, on a corrupt block, resynchronizing on the next sync
+// marker) as needed.
+func (r *Reader) HasNext() bool {
+	if r.err != nil {
+		return false
+	}
+	for r.blockLeft == 0 {
+		if err := r.nextBlock(); err != nil {
+			if err != io.EOF {
+				r.err = err
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// Err returns the first error encountered by HasNext, if any.
+func (r *Reader) Err() error { return r.err }
+
+// Next decodes the next record into v.
+func (r *Reader) Next(v interface{}) error {
+	if r.blockLeft == 0 {
+		return fmt.Errorf("ocf: Next called without a successful HasNext")
+	}
+	if err := r.datum.Read(v, r.blockDec); err != nil {
+		return fmt.Errorf("ocf: decoding record: %w", err)
+	}
+	r.blockLeft--
+	return nil
+}
+
+// nextBlock reads one block's count/size/sync framing and decompresses
+// its body. On a malformed block (bad codec bytes, a sync marker that
+// doesn't match), it scans forward for the next occurrence of the sync
+// marker and resumes from there, rather than failing the whole file.
+func (r *Reader) nextBlock() error {
+	count, size, err := readBlockHeader(r.r)
+	if err == io.EOF {
+		return io.EOF
+	}
+	if err != nil {
+		return r.resync(err)
+	}
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r.r, body); err != nil {
+		return r.resync(fmt.Errorf("ocf: reading block body: %w", err))
+	}
+
+	var trailer [syncSize]byte
+	if _, err := io.ReadFull(r.r, trailer[:]); err != nil {
+		return r.resync(fmt.Errorf("ocf: reading block sync: %w", err))
+	}
+	if trailer != r.sync {
+		return r.resync(fmt.Errorf("ocf: block sync marker mismatch"))
+	}
+
+	raw, err := r.decompress(body)
+	if err != nil {
+		return r.resync(err)
+	}
+
+	r.blockLeft = count
+	r.blockDec = avro.NewBinaryDecoder(raw)
+	return nil
+}
+
+// resync is the "seek to next sync" corruption recovery: it scans the
+// stream for the next occurrence of the file's sync marker and, if
+// found, resumes block reading immediately after it instead of
+// propagating blockErr and aborting the whole read.
+func (r *Reader) resync(blockErr error) error {
+	window := make([]byte, 0, syncSize)
+	for {
+		b, err := r.r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("%w (recovering from: %v)", err, blockErr)
+		}
+		if len(window) == syncSize {
+			copy(window, window[1:])
+			window = window[:syncSize-1]
+		}
+		window = append(window, b)
+		if len(window) == syncSize && bytes.Equal(window, r.sync[:]) {
+			return r.nextBlock()
+		}
+	}
+}
+
+func (r *Reader) decompress(body []byte) ([]byte, error) {
+	switch r.codec {
+	case Null:
+		return body, nil
+	case Deflate:
+		fr := flate.NewReader(bytes.NewReader(body))
+		defer fr.Close()
+		return io.ReadAll(fr)
+	case Snappy:
+		if len(body) < 4 {
+			return nil, fmt.Errorf("ocf: snappy block too short for checksum")
+		}
+		payload, checksum := body[:len(body)-4], body[len(body)-4:]
+		raw, err := snappy.Decode(nil, payload)
+		if err != nil {
+			return nil, err
+		}
+		want := uint32(checksum[0])<<24 | uint32(checksum[1])<<16 | uint32(checksum[2])<<8 | uint32(checksum[3])
+		if crc32.ChecksumIEEE(raw) != want {
+			return nil, fmt.Errorf("ocf: snappy checksum mismatch")
+		}
+		return raw, nil
+	default:
+		return nil, fmt.Errorf("ocf: unknown codec %q", r.codec)
+	}
+}
+
+// readBlockHeader reads a block's leading count and size longs.
+func readBlockHeader(r io.ByteReader) (count, size int64, err error) {
+	count, err = readZigzagLong(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	size, err = readZigzagLong(r)
+	return count, size, err
+}
+
+// readMetaMap decodes the header's metadata, an Avro map<bytes> encoded
+// as a sequence of (possibly negative, block-count-prefixed) blocks of
+// key/value pairs terminated by a zero-length block.
+func readMetaMap(r io.ByteReader) (map[string][]byte, error) {
+	meta := make(map[string][]byte)
+	for {
+		n, err := readZigzagLong(r)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return meta, nil
+		}
+		if n < 0 {
+			// A negative block count is followed by the byte size of
+			// the block, which this reader has no use for beyond
+			// draining entries individually below.
+			if _, err := readZigzagLong(r); err != nil {
+				return nil, err
+			}
+			n = -n
+		}
+		for i := int64(0); i < n; i++ {
+			key, err := readAvroBytes(r)
+			if err != nil {
+				return nil, err
+			}
+			value, err := readAvroBytes(r)
+			if err != nil {
+				return nil, err
+			}
+			meta[string(key)] = value
+		}
+	}
+}
+
+func readAvroBytes(r io.ByteReader) ([]byte, error) {
+	n, err := readZigzagLong(r)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, n)
+	for i := range buf {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		buf[i] = b
+	}
+	return buf, nil
+}
+
+// readZigzagLong decodes an Avro "long": a variable-length zigzag-encoded
+// integer, least-significant group first.
+func readZigzagLong(r io.ByteReader) (int64, error) {
+	var result uint64
+	var shift uint
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		result |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		shift += 7
+	}
+	return int64(result>>1) ^ -(int64(result) & 1), nil
+}
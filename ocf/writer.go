@@ -0,0 +1,207 @@
+package ocf
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/golang/snappy"
+	"github.com/tunein/go-avro"
+)
+
+// defaultBlockRecords is how many records Writer buffers before flushing
+// a block, absent an explicit WithBlockSize option.
+const defaultBlockRecords = 100
+
+// Writer emits an Avro Object Container File. Records passed to Write are
+// buffered and flushed as a block either every blockRecords calls (set
+// with WithBlockSize) or on
+// Close/Flush.
+type Writer struct {
+	w     io.Writer
+	sync  [syncSize]byte
+	codec Codec
+
+	schema avro.Schema
+	datum  *avro.PreparedDatumWriter
+
+	blockRecords int
+	pending      *bytes.Buffer
+	pendingEnc   avro.Encoder
+	pendingCount int64
+
+	headerWritten bool
+}
+
+// Option configures a Writer.
+type Option func(*Writer)
+
+// WithCodec sets the block compression codec. The default is Null.
+func WithCodec(codec Codec) Option {
+	return func(w *Writer) { w.codec = codec }
+}
+
+// WithBlockSize sets how many records Writer buffers per block. The
+// default is 100.
+func WithBlockSize(records int) Option {
+	return func(w *Writer) { w.blockRecords = records }
+}
+
+// NewWriter returns a Writer that encodes values matching schema to w. It
+// writes the OCF header, including a freshly generated sync marker, on
+// the first call to Write (or Close, for an empty file).
+func NewWriter(w io.Writer, schema avro.Schema, opts ...Option) (*Writer, error) {
+	ow := &Writer{
+		w:            w,
+		codec:        Null,
+		schema:       avro.Prepare(schema),
+		blockRecords: defaultBlockRecords,
+	}
+	if _, err := io.ReadFull(rand.Reader, ow.sync[:]); err != nil {
+		return nil, fmt.Errorf("ocf: generating sync marker: %w", err)
+	}
+	for _, opt := range opts {
+		opt(ow)
+	}
+	if err := ow.codec.validate(); err != nil {
+		return nil, err
+	}
+	ow.datum = avro.NewPreparedDatumWriter(ow.schema)
+	ow.resetPending()
+	return ow, nil
+}
+
+func (w *Writer) resetPending() {
+	w.pending = &bytes.Buffer{}
+	w.pendingEnc = avro.NewBinaryEncoder(w.pending)
+	w.pendingCount = 0
+}
+
+// Write buffers v, encoded against the writer's schema, into the current
+// block, flushing the block first if it is already full.
+func (w *Writer) Write(v interface{}) error {
+	if !w.headerWritten {
+		if err := w.writeHeader(); err != nil {
+			return err
+		}
+	}
+
+	if err := w.datum.Write(v, w.pendingEnc); err != nil {
+		return fmt.Errorf("ocf: encoding record: %w", err)
+	}
+	w.pendingCount++
+
+	if w.pendingCount >= int64(w.blockRecords) {
+		return w.Flush()
+	}
+	return nil
+}
+
+// Flush writes any buffered records as a single block.
+func (w *Writer) Flush() error {
+	if w.pendingCount == 0 {
+		return nil
+	}
+
+	raw := w.pending.Bytes()
+	compressed, err := w.compress(raw)
+	if err != nil {
+		return fmt.Errorf("ocf: compressing block: %w", err)
+	}
+
+	enc := avro.NewBinaryEncoder(w.w)
+	if err := enc.WriteLong(w.pendingCount); err != nil {
+		return err
+	}
+	if err := enc.WriteLong(int64(len(compressed))); err != nil {
+		return err
+	}
+	if _, err := w.w.Write(compressed); err != nil {
+		return err
+	}
+	if _, err := w.w.Write(w.sync[:]); err != nil {
+		return err
+	}
+
+	w.resetPending()
+	return nil
+}
+
+// Close flushes any pending block. It does not close the underlying
+// io.Writer.
+func (w *Writer) Close() error {
+	if !w.headerWritten {
+		if err := w.writeHeader(); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+func (w *Writer) writeHeader() error {
+	if _, err := w.w.Write(magic[:]); err != nil {
+		return err
+	}
+
+	meta := map[string][]byte{
+		metaSchemaKey: []byte(w.schema.String()),
+		metaCodecKey:  []byte(w.codec),
+	}
+
+	enc := avro.NewBinaryEncoder(w.w)
+	if err := enc.WriteMapStart(int64(len(meta))); err != nil {
+		return err
+	}
+	for k, v := range meta {
+		if err := enc.WriteString(k); err != nil {
+			return err
+		}
+		if err := enc.WriteBytes(v); err != nil {
+			return err
+		}
+	}
+	if err := enc.WriteMapNext(0); err != nil {
+		return err
+	}
+
+	if _, err := w.w.Write(w.sync[:]); err != nil {
+		return err
+	}
+	w.headerWritten = true
+	return nil
+}
+
+func (w *Writer) compress(raw []byte) ([]byte, error) {
+	switch w.codec {
+	case Null:
+		return raw, nil
+	case Deflate:
+		var buf bytes.Buffer
+		fw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := fw.Write(raw); err != nil {
+			return nil, err
+		}
+		if err := fw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case Snappy:
+		compressed := snappy.Encode(nil, raw)
+		checksum := crc32.ChecksumIEEE(raw)
+		out := make([]byte, len(compressed)+4)
+		copy(out, compressed)
+		out[len(compressed)+0] = byte(checksum >> 24)
+		out[len(compressed)+1] = byte(checksum >> 16)
+		out[len(compressed)+2] = byte(checksum >> 8)
+		out[len(compressed)+3] = byte(checksum)
+		return out, nil
+	default:
+		return nil, fmt.Errorf("ocf: unknown codec %q", w.codec)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"reflect"
 	"sync"
+	"sync/atomic"
 )
 
 /*
@@ -17,8 +18,15 @@ func Prepare(schema Schema) Schema {
 }
 
 // PrepareResolving handles reading where the writer and reader schemas
-// differ but are compatible. Support is currently limited to adding or
-// removing record fields.
+// differ but are compatible, implementing the resolution rules from the
+// Avro spec: record fields may be added or removed (reader-only fields
+// are filled from their default), numeric types may be promoted
+// (int->long->float->double, and string<->bytes), union branches are
+// matched by schema or promotion, fixed sizes must match, and
+// records/enums may be matched by name alias (fixed types by name only).
+// Enum values decode to the writer's symbol name: they are not checked
+// against the reader's symbols, and no reader default symbol is
+// substituted.
 func PrepareResolving(writer, reader Schema) Schema {
 	job := prepareJob{
 		seen: make(map[Schema]Schema),
@@ -37,15 +45,19 @@ func (job *prepareJob) prepare(writer, reader Schema) Schema {
 	case *RecordSchema:
 		output = job.prepareRecordSchema(writer, reader.(*RecordSchema))
 	case *RecursiveSchema:
+		// writer.Actual is the *RecordSchema being referenced, not the
+		// *RecursiveSchema wrapper, so seen must be (and is) keyed by
+		// it: prepareRecordSchema registers a record before walking its
+		// fields, so a self-reference reaches this case and finds the
+		// (still being built) preparedRecordSchema already present.
 		if seen := job.seen[writer.Actual]; seen != nil {
 			return seen
-		} else {
-			return job.prepare(writer.Actual, writer.Actual)
 		}
+		return job.prepare(writer.Actual, writer.Actual)
 	case *UnionSchema:
-		output = job.prepareUnionSchema(writer)
+		output = job.prepareUnionSchema(writer, reader)
 	case *ArraySchema:
-		output = job.prepareArraySchema(writer)
+		output = job.prepareArraySchema(writer, reader)
 	default:
 		return writer
 	}
@@ -53,20 +65,39 @@ func (job *prepareJob) prepare(writer, reader Schema) Schema {
 	return output
 }
 
-func (job *prepareJob) prepareUnionSchema(input *UnionSchema) Schema {
+func (job *prepareJob) prepareUnionSchema(input *UnionSchema, reader Schema) Schema {
 	output := &UnionSchema{
 		Types: make([]Schema, len(input.Types)),
 	}
+	// Match each writer branch to its reader counterpart by schema kind
+	// so a promoted/aliased type inside the branch resolves recursively
+	// instead of only against itself; a branch with no corresponding
+	// reader type (the reader union dropped it, or reader isn't a union
+	// at all) falls back to resolving against itself.
+	readerUnion, _ := reader.(*UnionSchema)
 	for i, t := range input.Types {
-		output.Types[i] = job.prepare(t, t)
+		readerType := t
+		if readerUnion != nil {
+			for _, branch := range readerUnion.Types {
+				if sameSchemaKind(t, branch) {
+					readerType = branch
+					break
+				}
+			}
+		}
+		output.Types[i] = job.prepare(t, readerType)
 	}
 	return output
 }
 
-func (job *prepareJob) prepareArraySchema(input *ArraySchema) Schema {
+func (job *prepareJob) prepareArraySchema(input *ArraySchema, reader Schema) Schema {
+	items := input.Items
+	if readerArray, ok := reader.(*ArraySchema); ok {
+		items = readerArray.Items
+	}
 	return &ArraySchema{
 		Properties: input.Properties,
-		Items:      job.prepare(input.Items, input.Items),
+		Items:      job.prepare(input.Items, items),
 	}
 }
 func (job *prepareJob) prepareMapSchema(input *MapSchema) Schema {
@@ -80,69 +111,451 @@ func (job *prepareJob) prepareRecordSchema(writer, reader *RecordSchema) *prepar
 	output := &preparedRecordSchema{
 		RecordSchema: *writer,
 		ReaderSchema: reader,
-		pool:         sync.Pool{New: func() interface{} { return make(map[reflect.Type]*recordPlan) }},
 	}
+	output.cache.Store(make(map[reflect.Type]*recordPlan))
+
+	// Register before walking fields: a self-referential record (e.g.
+	// {name, children: array<Node>}) reaches *RecursiveSchema for its
+	// own field and must find this (still incomplete) output here
+	// rather than recursing into prepareRecordSchema again. output is a
+	// pointer, so filling in output.Fields below is visible to whoever
+	// already holds this reference.
+	job.seen[writer] = output
+
+	readerFieldTypes := make(map[string]Schema, len(reader.Fields))
+	for _, field := range reader.Fields {
+		readerFieldTypes[field.Name] = field.Type
+	}
+
 	output.Fields = nil
 	for _, field := range writer.Fields {
+		// Resolve nested types (sub-records, array/map items, union
+		// branches) against the reader's matching field, not against
+		// themselves, so promotions/aliases apply recursively rather
+		// than only at the top level of the record. A field the reader
+		// dropped has no counterpart to resolve against, so it is
+		// prepared against itself, matching how resolveField treats it
+		// (actionSkipWriterField reads it verbatim off the wire).
+		readerType := field.Type
+		if rt, ok := readerFieldTypes[field.Name]; ok {
+			readerType = rt
+		}
 		output.Fields = append(output.Fields, &SchemaField{
 			Name:    field.Name,
 			Doc:     field.Doc,
 			Default: field.Default,
-			Type:    job.prepare(field.Type, field.Type),
+			Type:    job.prepare(field.Type, readerType),
 		})
 	}
+
+	// Fields the reader expects but the writer never produced are not
+	// part of the wire format, so they are tracked separately; getPlan
+	// fills them from their default when building the decode plan.
+	writerFieldNames := make(map[string]struct{}, len(writer.Fields))
+	for _, field := range writer.Fields {
+		writerFieldNames[field.Name] = struct{}{}
+	}
+	for _, field := range reader.Fields {
+		if _, ok := writerFieldNames[field.Name]; ok {
+			continue
+		}
+		if field.Default == nil {
+			output.resolveErr = fmt.Errorf("reader field %q has no writer field and no default value", field.Name)
+			continue
+		}
+		output.readerOnlyFields = append(output.readerOnlyFields, field)
+	}
+
 	return output
 }
 
+// resolveField decides how a writer field should be handled against the
+// reader schema: decoded straight through, promoted to a different
+// reader type, remapped into a union branch, or skipped because the
+// reader dropped it.
+func resolveField(writerField *SchemaField, readerFields map[string]*SchemaField) (action fieldAction, readerType Schema, err error) {
+	// SchemaField has no Aliases field in this tree, so fields are
+	// matched by name only; a writer field the reader renamed looks
+	// like one the reader dropped (actionSkipWriterField) rather than a
+	// rename.
+	readerField, ok := readerFields[writerField.Name]
+	if !ok {
+		return actionSkipWriterField, writerField.Type, nil
+	}
+
+	match, promotion, err := resolveType(writerField.Type, readerField.Type)
+	if err != nil {
+		return actionDecode, nil, fmt.Errorf("field %q: %w", writerField.Name, err)
+	}
+	return match, promotion, nil
+}
+
+// resolveType determines how a writer schema resolves against a reader
+// schema, per the Avro spec's matching and promotion rules.
+func resolveType(writer, reader Schema) (fieldAction, Schema, error) {
+	// The writer-union case must be tested before the reader-union case:
+	// Prepare's identity resolution (and every nullable ["null", T]
+	// field) has writer and reader both unions, and a whole writer union
+	// can never itself resolve against one specific reader branch, so
+	// testing the reader-union branch first would always fail that
+	// common case with "no branch of reader union matches".
+	if writerUnion, ok := writer.(*UnionSchema); ok {
+		if readerUnion, ok := reader.(*UnionSchema); ok {
+			// Both sides are unions: every writer branch must match some
+			// reader branch; the concrete branch is picked at decode
+			// time from the writer's own discriminant.
+			for _, wb := range writerUnion.Types {
+				matched := false
+				for _, rb := range readerUnion.Types {
+					if _, _, err := resolveType(wb, rb); err == nil {
+						matched = true
+						break
+					}
+				}
+				if !matched {
+					return actionDecode, nil, fmt.Errorf("writer union branch %v has no matching reader union branch", wb)
+				}
+			}
+			return actionUnionRemap, reader, nil
+		}
+
+		// A writer union resolves against a single reader type if every
+		// branch resolves against it; the concrete branch is chosen at
+		// decode time from the writer's union discriminant, so report
+		// the first successful match just to validate compatibility up
+		// front.
+		for _, branch := range writerUnion.Types {
+			if _, _, err := resolveType(branch, reader); err != nil {
+				return actionDecode, nil, fmt.Errorf("writer union branch %v does not resolve against reader schema: %w", branch, err)
+			}
+		}
+		return actionUnionRemap, reader, nil
+	}
+
+	if readerUnion, ok := reader.(*UnionSchema); ok {
+		for _, branch := range readerUnion.Types {
+			if _, _, err := resolveType(writer, branch); err == nil {
+				return actionUnionRemap, branch, nil
+			}
+		}
+		return actionDecode, nil, fmt.Errorf("no branch of reader union matches writer schema %v", writer)
+	}
+
+	if sameSchemaKind(writer, reader) {
+		switch w := writer.(type) {
+		case *EnumSchema:
+			r := reader.(*EnumSchema)
+			if !namesMatch(w.Name, w.Aliases, r.Name) {
+				return actionDecode, nil, fmt.Errorf("enum name %q does not match reader enum %q", w.Name, r.Name)
+			}
+			return actionDecode, reader, nil
+		case *FixedSchema:
+			// FixedSchema has no Aliases field in this tree, so fixed
+			// types are matched by name only (no alias fallback).
+			r := reader.(*FixedSchema)
+			if w.Name != r.Name {
+				return actionDecode, nil, fmt.Errorf("fixed name %q does not match reader fixed %q", w.Name, r.Name)
+			}
+			if w.Size != r.Size {
+				return actionDecode, nil, fmt.Errorf("fixed %q size %d does not match reader size %d", w.Name, w.Size, r.Size)
+			}
+			return actionDecode, reader, nil
+		case *RecordSchema:
+			r := reader.(*RecordSchema)
+			if !namesMatch(w.Name, w.Aliases, r.Name) {
+				return actionDecode, nil, fmt.Errorf("record name %q does not match reader record %q", w.Name, r.Name)
+			}
+			return actionDecode, reader, nil
+		default:
+			return actionDecode, reader, nil
+		}
+	}
+
+	if isPromotable(writer, reader) {
+		return actionPromote, reader, nil
+	}
+
+	return actionDecode, nil, fmt.Errorf("writer schema %v is not compatible with reader schema %v", writer, reader)
+}
+
+// sameSchemaKind reports whether writer and reader are the same concrete
+// schema type (ignoring name/field differences, which callers check
+// separately).
+func sameSchemaKind(writer, reader Schema) bool {
+	return reflect.TypeOf(writer) == reflect.TypeOf(reader)
+}
+
+// namesMatch reports whether a reader's name matches the writer's name
+// or one of the writer's declared aliases.
+func namesMatch(writerName string, writerAliases []string, readerName string) bool {
+	if writerName == readerName {
+		return true
+	}
+	for _, alias := range writerAliases {
+		if alias == readerName {
+			return true
+		}
+	}
+	return false
+}
+
+// isPromotable implements the Avro spec's numeric and string/bytes
+// promotion table: int -> long, float, double; long -> float, double;
+// float -> double; string <-> bytes.
+func isPromotable(writer, reader Schema) bool {
+	switch writer.(type) {
+	case *IntSchema:
+		switch reader.(type) {
+		case *LongSchema, *FloatSchema, *DoubleSchema:
+			return true
+		}
+	case *LongSchema:
+		switch reader.(type) {
+		case *FloatSchema, *DoubleSchema:
+			return true
+		}
+	case *FloatSchema:
+		switch reader.(type) {
+		case *DoubleSchema:
+			return true
+		}
+	case *StringSchema:
+		switch reader.(type) {
+		case *BytesSchema:
+			return true
+		}
+	case *BytesSchema:
+		switch reader.(type) {
+		case *StringSchema:
+			return true
+		}
+	}
+	return false
+}
+
+// fieldAction records, per decoded field, how the prepared schema
+// reconciled a writer field against the reader schema so the decoder can
+// act on it without re-running resolution on every record.
+type fieldAction int
+
+const (
+	// actionDecode reads the field straight off the wire into the
+	// reader's type; writer and reader agree.
+	actionDecode fieldAction = iota
+	// actionSkipWriterField reads and discards the field; the reader
+	// dropped it.
+	actionSkipWriterField
+	// actionUseDefault does not read anything off the wire; the field
+	// only exists on the reader side and is filled from its default.
+	actionUseDefault
+	// actionPromote reads the field using the writer's (narrower) type
+	// and widens it to the reader's type, per the Avro promotion table.
+	actionPromote
+	// actionUnionRemap reads the field as a union using the writer's
+	// branch, then stores it into the reader's matching branch/type.
+	actionUnionRemap
+)
+
 type preparedRecordSchema struct {
 	RecordSchema // WriterSchema
 	ReaderSchema *RecordSchema
-	pool         sync.Pool
+
+	// cache holds a map[reflect.Type]*recordPlan, read lock-free on the
+	// hot path. A miss takes publishMu, copies the map, inserts the new
+	// plan, and stores the copy, so the plan is permanently cached for
+	// the lifetime of this preparedRecordSchema without the per-call
+	// allocation and cross-goroutine churn sync.Pool had.
+	cache     atomic.Value
+	publishMu sync.Mutex
+
+	// readerOnlyFields are fields present in ReaderSchema but absent
+	// from the writer's wire format; they are never read from the
+	// encoder and are always filled from their default value.
+	readerOnlyFields []*SchemaField
+	// resolveErr is set by prepareRecordSchema when a reader field has
+	// neither a matching writer field nor a default; it is surfaced by
+	// getPlan rather than failing at prepare time, matching how other
+	// resolution errors (missing struct fields) are reported.
+	resolveErr error
+
+	// buildingMu and building track the plans getPlan is building right
+	// now, one per struct type. A caller that asks for a t whose first
+	// build is still running on another goroutine blocks on that
+	// build's deferred.done and gets its result, instead of seeing a
+	// half-built plan or building it a second time (see getPlan).
+	buildingMu sync.Mutex
+	building   map[reflect.Type]*deferredDecoder
+}
+
+// deferredDecoder records one in-flight getPlan build: plan is the
+// *recordPlan that build fills in, err is the error it returns, and done
+// is closed once it finishes, so a concurrent first-time caller for the
+// same type can wait for the real result. No field decoder holds a
+// deferredDecoder: a self-referential field's decoder calls the nested
+// record's Read, which looks the finished plan up when it runs.
+type deferredDecoder struct {
+	plan *recordPlan
+	err  error
+	done chan struct{}
 }
 
+// getPlan is the structPlanner for rs: it builds (and caches) the decode
+// and encode plans for struct type t together, from one pass over the
+// type's reflected field info, so DatumReader and DatumWriter agree on
+// field indices, names, and defaults without planning independently.
 func (rs *preparedRecordSchema) getPlan(t reflect.Type) (plan *recordPlan, err error) {
-	cache := rs.pool.Get().(map[reflect.Type]*recordPlan)
+	cache := rs.cache.Load().(map[reflect.Type]*recordPlan)
 	if plan = cache[t]; plan != nil {
-		rs.pool.Put(cache)
-		return
+		return plan, nil
+	}
+
+	if rs.resolveErr != nil {
+		return nil, rs.resolveErr
 	}
 
+	rs.buildingMu.Lock()
+	if deferred, ok := rs.building[t]; ok {
+		rs.buildingMu.Unlock()
+		// Building a plan is a synchronous walk over reflected field
+		// info and schemas with no recursive call back into getPlan
+		// for the same (rs, t) on this goroutine's own stack (a
+		// nested preparedRecordSchema field only calls Read/Write,
+		// which looks the finished plan up later, not while this one
+		// is being built) - so any caller that lands here is a
+		// distinct, concurrent first-time build for t and must wait
+		// for the real plan rather than racing ahead with deferred's
+		// still-nil decodePlan/encodePlan.
+		<-deferred.done
+		return deferred.plan, deferred.err
+	}
+	plan = &recordPlan{}
+	deferred := &deferredDecoder{plan: plan, done: make(chan struct{})}
+	if rs.building == nil {
+		rs.building = make(map[reflect.Type]*deferredDecoder)
+	}
+	rs.building[t] = deferred
+	rs.buildingMu.Unlock()
+
+	defer func() {
+		deferred.err = err
+		close(deferred.done)
+		rs.buildingMu.Lock()
+		delete(rs.building, t)
+		rs.buildingMu.Unlock()
+	}()
+
 	// Use the reflectmap to get field info.
 	ri := reflectEnsureRi(t)
 
-	readerFieldNames := make(map[string]struct{})
+	readerFields := make(map[string]*SchemaField, len(rs.ReaderSchema.Fields))
 	for _, schemaField := range rs.ReaderSchema.Fields {
-		readerFieldNames[schemaField.Name] = struct{}{}
+		readerFields[schemaField.Name] = schemaField
 	}
 
-	decodePlan := make([]structFieldPlan, len(rs.Fields))
-	for i, schemafield := range rs.Fields {
-		_, readerHasField := readerFieldNames[schemafield.Name]
+	decodePlan := make([]structFieldPlan, 0, len(rs.Fields)+len(rs.readerOnlyFields))
+	for _, schemafield := range rs.Fields {
+		action, readerType, resolveErr := resolveField(schemafield, readerFields)
+		if resolveErr != nil {
+			err = fmt.Errorf("type %v: %w", t, resolveErr)
+			continue
+		}
+
 		index, ok := ri.names[schemafield.Name]
-		if !ok && readerHasField {
+		if !ok && action != actionSkipWriterField {
 			err = fmt.Errorf("Type %v does not have field %s required for decoding schema", t, schemafield.Name)
 		}
-		entry := &decodePlan[i]
+
+		decodePlan = append(decodePlan, structFieldPlan{})
+		entry := &decodePlan[len(decodePlan)-1]
 		entry.schema = schemafield.Type
 		entry.name = schemafield.Name
 		entry.index = index
+		entry.action = action
+		entry.readerSchema = readerType
 		entry.dec = specificDecoder(entry)
 	}
 
-	plan = &recordPlan{
-		// Over time, we will create decode/encode plans for more things.
-		decodePlan: decodePlan,
+	for _, schemafield := range rs.readerOnlyFields {
+		index, ok := ri.names[schemafield.Name]
+		if !ok {
+			continue
+		}
+		decodePlan = append(decodePlan, structFieldPlan{})
+		entry := &decodePlan[len(decodePlan)-1]
+		entry.schema = schemafield.Type
+		entry.name = schemafield.Name
+		entry.index = index
+		entry.action = actionUseDefault
+		entry.defaultValue = schemafield.Default
 	}
-	cache[t] = plan
-	rs.pool.Put(cache)
+
+	// The encode side shares this same planner: it only ever writes
+	// rs.Fields (the schema being encoded into), so it needs no reader
+	// resolution, just the struct's field indices from ri.
+	encodePlan := make([]structFieldPlan, 0, len(rs.Fields))
+	for _, schemafield := range rs.Fields {
+		index, ok := ri.names[schemafield.Name]
+		if !ok {
+			err = fmt.Errorf("Type %v does not have field %s required for encoding schema", t, schemafield.Name)
+			continue
+		}
+
+		encodePlan = append(encodePlan, structFieldPlan{})
+		entry := &encodePlan[len(encodePlan)-1]
+		entry.schema = schemafield.Type
+		entry.name = schemafield.Name
+		entry.index = index
+		entry.enc = specificEncoder(entry)
+	}
+
+	// A failed build is not cached, so every call for t reports the error.
+	if err != nil {
+		return nil, err
+	}
+
+	plan.decodePlan = decodePlan
+	plan.encodePlan = encodePlan
+
+	rs.publishMu.Lock()
+	old := rs.cache.Load().(map[reflect.Type]*recordPlan)
+	next := make(map[reflect.Type]*recordPlan, len(old)+1)
+	for k, v := range old {
+		next[k] = v
+	}
+	next[t] = plan
+	rs.cache.Store(next)
+	rs.publishMu.Unlock()
 	return
 }
 
-// This is used
-var sdr sDatumReader
+// structFieldPlan is one planned field, shared by the decode and encode
+// sides of a record's plan: the writer schema, the resolved reader type
+// (decode side only), the resolution action, and the struct field index
+// the specialized codec reads from or writes into. specificDecoder and
+// specificEncoder each turn one of these into a closure over schema/
+// readerSchema once, at plan-build time. Read still switches on action
+// for every decoded record, to fill reader-only fields from defaultValue
+// and to skip dropped writer fields.
+type structFieldPlan struct {
+	schema       Schema
+	readerSchema Schema
+	name         string
+	index        []int
+	action       fieldAction
+	defaultValue interface{}
+	dec          specificDecoderFunc
+	enc          specificEncoderFunc
+}
 
+// recordPlan is the output of the shared structPlanner: a pair of plans
+// for one (*preparedRecordSchema, reflect.Type) combination, built once
+// by getPlan and cached for the lifetime of the preparedRecordSchema.
+// decodePlan and encodePlan are built from the same ri/field-index pass,
+// so both sides of a round trip agree on field names and indices even
+// though they walk the struct independently.
 type recordPlan struct {
 	decodePlan []structFieldPlan
+	encodePlan []structFieldPlan
 }
 
 // For right now, until we implement more optimizations,
@@ -0,0 +1,531 @@
+package avro
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// specificDecoderFunc reads one struct field's value, as planned by a
+// structFieldPlan on the decode side, out of dec. It is the decode-side
+// counterpart of the function specificEncoder builds for encodePlan
+// entries.
+type specificDecoderFunc func(v reflect.Value, dec Decoder) error
+
+// specificDecoder picks a specialized decoder for entry based on the
+// resolution action getPlan computed for it, so Read can decode a
+// record with a tight loop over decodePlan instead of re-resolving
+// writer against reader on every value. actionUseDefault never reads
+// the wire at all, so it is handled directly by the decode loop in Read
+// rather than being given a dec here.
+func specificDecoder(entry *structFieldPlan) specificDecoderFunc {
+	switch entry.action {
+	case actionSkipWriterField:
+		return skipDecoder(entry.schema)
+	case actionUseDefault:
+		return nil
+	case actionPromote:
+		return promotedDecoder(entry.schema, entry.readerSchema)
+	case actionUnionRemap:
+		return unionRemapDecoder(entry.schema, entry.readerSchema)
+	}
+	return decoderForSchema(entry.schema, entry.name)
+}
+
+// decoderForSchema builds the straight-through decoder for a schema kind
+// where writer and reader already agree (action == actionDecode), and is
+// reused by the promotion/union-remap helpers below to decode a branch
+// or a promoted value's underlying wire representation.
+func decoderForSchema(schema Schema, name string) specificDecoderFunc {
+	switch schema := schema.(type) {
+	case *preparedRecordSchema:
+		return func(v reflect.Value, dec Decoder) error {
+			// A nested record field is commonly a pointer in the Go
+			// struct (notably a self-referential one, e.g. Next
+			// *Node); schema.Read wants something it can take
+			// reflect.Indirect of down to the struct, which a nil
+			// pointer isn't, so allocate before handing it over.
+			if v.Kind() == reflect.Ptr {
+				if v.IsNil() {
+					v.Set(reflect.New(v.Type().Elem()))
+				}
+				return schema.Read(v.Interface(), dec)
+			}
+			return schema.Read(v.Addr().Interface(), dec)
+		}
+	case *BooleanSchema:
+		return func(v reflect.Value, dec Decoder) error {
+			b, err := dec.ReadBoolean()
+			if err != nil {
+				return err
+			}
+			v.SetBool(b)
+			return nil
+		}
+	case *IntSchema:
+		return func(v reflect.Value, dec Decoder) error {
+			n, err := dec.ReadInt()
+			if err != nil {
+				return err
+			}
+			v.SetInt(int64(n))
+			return nil
+		}
+	case *LongSchema:
+		return func(v reflect.Value, dec Decoder) error {
+			n, err := dec.ReadLong()
+			if err != nil {
+				return err
+			}
+			v.SetInt(n)
+			return nil
+		}
+	case *FloatSchema:
+		return func(v reflect.Value, dec Decoder) error {
+			f, err := dec.ReadFloat()
+			if err != nil {
+				return err
+			}
+			v.SetFloat(float64(f))
+			return nil
+		}
+	case *DoubleSchema:
+		return func(v reflect.Value, dec Decoder) error {
+			f, err := dec.ReadDouble()
+			if err != nil {
+				return err
+			}
+			v.SetFloat(f)
+			return nil
+		}
+	case *StringSchema:
+		return func(v reflect.Value, dec Decoder) error {
+			s, err := dec.ReadString()
+			if err != nil {
+				return err
+			}
+			v.SetString(s)
+			return nil
+		}
+	case *BytesSchema:
+		return func(v reflect.Value, dec Decoder) error {
+			b, err := dec.ReadBytes()
+			if err != nil {
+				return err
+			}
+			v.SetBytes(b)
+			return nil
+		}
+	case *FixedSchema:
+		return func(v reflect.Value, dec Decoder) error {
+			b, err := dec.ReadFixed(schema.Size)
+			if err != nil {
+				return err
+			}
+			reflect.Copy(v, reflect.ValueOf(b))
+			return nil
+		}
+	case *EnumSchema:
+		return func(v reflect.Value, dec Decoder) error {
+			idx, err := dec.ReadEnum()
+			if err != nil {
+				return err
+			}
+			if int(idx) < 0 || int(idx) >= len(schema.Symbols) {
+				return fmt.Errorf("enum %q: symbol index %d out of range", schema.Name, idx)
+			}
+			v.SetString(schema.Symbols[idx])
+			return nil
+		}
+	case *NullSchema:
+		return func(v reflect.Value, dec Decoder) error {
+			return nil
+		}
+	case *ArraySchema:
+		itemDec := decoderForSchema(schema.Items, name)
+		return func(v reflect.Value, dec Decoder) error {
+			itemType := v.Type().Elem()
+			v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+			for {
+				n, err := dec.ReadArrayStart()
+				if err != nil {
+					return err
+				}
+				for ; n > 0; n-- {
+					item := reflect.New(itemType).Elem()
+					if err := itemDec(item, dec); err != nil {
+						return err
+					}
+					v.Set(reflect.Append(v, item))
+				}
+				n, err = dec.ReadArrayNext()
+				if err != nil {
+					return err
+				}
+				if n == 0 {
+					return nil
+				}
+			}
+		}
+	case *MapSchema:
+		valueDec := decoderForSchema(schema.Values, name)
+		return func(v reflect.Value, dec Decoder) error {
+			valueType := v.Type().Elem()
+			v.Set(reflect.MakeMap(v.Type()))
+			for {
+				n, err := dec.ReadMapStart()
+				if err != nil {
+					return err
+				}
+				for ; n > 0; n-- {
+					key, err := dec.ReadString()
+					if err != nil {
+						return err
+					}
+					value := reflect.New(valueType).Elem()
+					if err := valueDec(value, dec); err != nil {
+						return err
+					}
+					v.SetMapIndex(reflect.ValueOf(key), value)
+				}
+				n, err = dec.ReadMapNext()
+				if err != nil {
+					return err
+				}
+				if n == 0 {
+					return nil
+				}
+			}
+		}
+	case *UnionSchema:
+		return unionRemapDecoder(schema, schema)
+	default:
+		return func(v reflect.Value, dec Decoder) error {
+			return fmt.Errorf("field %q: no specialized decoder for schema %T, falling back to reflection not implemented here", name, schema)
+		}
+	}
+}
+
+// skipDecoder reads a writer field the reader schema dropped (resolved
+// as actionSkipWriterField) and discards it, so its bytes are consumed
+// off the wire without needing a struct field to store into.
+func skipDecoder(schema Schema) specificDecoderFunc {
+	switch schema := schema.(type) {
+	case *ArraySchema:
+		itemSkip := skipDecoder(schema.Items)
+		return func(_ reflect.Value, dec Decoder) error {
+			for {
+				n, err := dec.ReadArrayStart()
+				if err != nil {
+					return err
+				}
+				for ; n > 0; n-- {
+					if err := itemSkip(reflect.Value{}, dec); err != nil {
+						return err
+					}
+				}
+				n, err = dec.ReadArrayNext()
+				if err != nil {
+					return err
+				}
+				if n == 0 {
+					return nil
+				}
+			}
+		}
+	case *MapSchema:
+		valueSkip := skipDecoder(schema.Values)
+		return func(_ reflect.Value, dec Decoder) error {
+			for {
+				n, err := dec.ReadMapStart()
+				if err != nil {
+					return err
+				}
+				for ; n > 0; n-- {
+					if _, err := dec.ReadString(); err != nil {
+						return err
+					}
+					if err := valueSkip(reflect.Value{}, dec); err != nil {
+						return err
+					}
+				}
+				n, err = dec.ReadMapNext()
+				if err != nil {
+					return err
+				}
+				if n == 0 {
+					return nil
+				}
+			}
+		}
+	case *UnionSchema:
+		return func(_ reflect.Value, dec Decoder) error {
+			idx, err := dec.ReadInt()
+			if err != nil {
+				return err
+			}
+			if int(idx) < 0 || int(idx) >= len(schema.Types) {
+				return fmt.Errorf("union branch index %d out of range", idx)
+			}
+			return skipDecoder(schema.Types[idx])(reflect.Value{}, dec)
+		}
+	case *preparedRecordSchema:
+		return func(_ reflect.Value, dec Decoder) error {
+			for _, field := range schema.Fields {
+				if err := skipDecoder(field.Type)(reflect.Value{}, dec); err != nil {
+					return fmt.Errorf("field %q: %w", field.Name, err)
+				}
+			}
+			return nil
+		}
+	case *RecordSchema:
+		return func(_ reflect.Value, dec Decoder) error {
+			for _, field := range schema.Fields {
+				if err := skipDecoder(field.Type)(reflect.Value{}, dec); err != nil {
+					return fmt.Errorf("field %q: %w", field.Name, err)
+				}
+			}
+			return nil
+		}
+	default:
+		// Every remaining schema kind (bool/int/long/float/double/
+		// string/bytes/fixed/enum/null) has no sub-structure to walk,
+		// so reuse the straight-through decoder against a scratch
+		// value of the matching Go type and throw the result away.
+		dec := decoderForSchema(schema, "")
+		scratchType := scratchTypeFor(schema)
+		return func(_ reflect.Value, d Decoder) error {
+			return dec(reflect.New(scratchType).Elem(), d)
+		}
+	}
+}
+
+// scratchTypeFor returns a throwaway Go type wide enough to hold a value
+// decoded for schema, used only so skipDecoder can call the ordinary
+// specialized decoders (which expect an addressable reflect.Value of the
+// right kind) and discard the result.
+func scratchTypeFor(schema Schema) reflect.Type {
+	switch schema.(type) {
+	case *BooleanSchema:
+		return reflect.TypeOf(false)
+	case *IntSchema, *LongSchema:
+		return reflect.TypeOf(int64(0))
+	case *FloatSchema:
+		return reflect.TypeOf(float32(0))
+	case *DoubleSchema:
+		return reflect.TypeOf(float64(0))
+	case *StringSchema, *EnumSchema:
+		return reflect.TypeOf("")
+	case *BytesSchema, *FixedSchema:
+		return reflect.TypeOf([]byte(nil))
+	default:
+		return reflect.TypeOf((*interface{})(nil)).Elem()
+	}
+}
+
+// promotedDecoder reads a value off the wire using writerSchema's
+// (narrower) codec and widens it into readerSchema's Go representation,
+// per the Avro promotion table resolveType validated at prepare time.
+func promotedDecoder(writerSchema, readerSchema Schema) specificDecoderFunc {
+	switch writerSchema.(type) {
+	case *IntSchema:
+		return func(v reflect.Value, dec Decoder) error {
+			n, err := dec.ReadInt()
+			if err != nil {
+				return err
+			}
+			return setPromoted(v, readerSchema, int64(n), float64(n))
+		}
+	case *LongSchema:
+		return func(v reflect.Value, dec Decoder) error {
+			n, err := dec.ReadLong()
+			if err != nil {
+				return err
+			}
+			return setPromoted(v, readerSchema, n, float64(n))
+		}
+	case *FloatSchema:
+		return func(v reflect.Value, dec Decoder) error {
+			f, err := dec.ReadFloat()
+			if err != nil {
+				return err
+			}
+			return setPromoted(v, readerSchema, int64(f), float64(f))
+		}
+	case *StringSchema:
+		return func(v reflect.Value, dec Decoder) error {
+			s, err := dec.ReadString()
+			if err != nil {
+				return err
+			}
+			v.SetBytes([]byte(s))
+			return nil
+		}
+	case *BytesSchema:
+		return func(v reflect.Value, dec Decoder) error {
+			b, err := dec.ReadBytes()
+			if err != nil {
+				return err
+			}
+			v.SetString(string(b))
+			return nil
+		}
+	}
+	return func(v reflect.Value, dec Decoder) error {
+		return fmt.Errorf("no promotion decoder for writer schema %T", writerSchema)
+	}
+}
+
+// setPromoted stores a numeric value widened from the writer's type into
+// v according to readerSchema, the only two shapes a numeric promotion
+// can land on: a wider integer (long) or a floating point type.
+func setPromoted(v reflect.Value, readerSchema Schema, i int64, f float64) error {
+	switch readerSchema.(type) {
+	case *LongSchema:
+		v.SetInt(i)
+	case *FloatSchema, *DoubleSchema:
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("cannot promote into reader schema %T", readerSchema)
+	}
+	return nil
+}
+
+// unionRemapDecoder reads a union discriminant off the wire using
+// writerSchema's branches (or, when the writer field itself isn't a
+// union, reads it straight through) and stores the result into v,
+// growing a nil pointer branch as needed and zeroing it for a null
+// branch. When readerSchema is a single type that the writer's value (or
+// writer branch) promotes to, the value is widened with promotedDecoder.
+func unionRemapDecoder(writerSchema, readerSchema Schema) specificDecoderFunc {
+	writerUnion, ok := writerSchema.(*UnionSchema)
+	if !ok {
+		// The reader is the union; the writer produced a single
+		// concrete value that must land in the reader's matching
+		// branch.
+		branchDec := decoderForSchema(writerSchema, "")
+		if isPromotable(writerSchema, readerSchema) {
+			branchDec = promotedDecoder(writerSchema, readerSchema)
+		}
+		return func(v reflect.Value, dec Decoder) error {
+			return assignBranch(v, branchDec, dec)
+		}
+	}
+
+	branchDecoders := make([]specificDecoderFunc, len(writerUnion.Types))
+	for i, t := range writerUnion.Types {
+		branchDecoders[i] = decoderForSchema(t, "")
+		if isPromotable(t, readerSchema) {
+			branchDecoders[i] = promotedDecoder(t, readerSchema)
+		}
+	}
+	return func(v reflect.Value, dec Decoder) error {
+		idx, err := dec.ReadInt()
+		if err != nil {
+			return err
+		}
+		if int(idx) < 0 || int(idx) >= len(writerUnion.Types) {
+			return fmt.Errorf("union branch index %d out of range", idx)
+		}
+		if _, isNull := writerUnion.Types[idx].(*NullSchema); isNull {
+			if v.Kind() == reflect.Ptr {
+				v.Set(reflect.Zero(v.Type()))
+			}
+			return nil
+		}
+		return assignBranch(v, branchDecoders[idx], dec)
+	}
+}
+
+// assignBranch decodes a non-null union branch using dec, growing v (a
+// nullable field is typically a pointer in the Go struct) if needed
+// before handing the pointee to branchDec.
+func assignBranch(v reflect.Value, branchDec specificDecoderFunc, dec Decoder) error {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	return branchDec(v, dec)
+}
+
+// setDefault stores a reader-only field's schema default (parsed from
+// JSON into one of the usual Go dynamic types) into v, for fields
+// actionUseDefault marks as never read off the wire.
+func setDefault(v reflect.Value, def interface{}) error {
+	if def == nil {
+		switch v.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+			v.Set(reflect.Zero(v.Type()))
+		}
+		return nil
+	}
+	rv := reflect.ValueOf(def)
+	if rv.Type().ConvertibleTo(v.Type()) {
+		v.Set(rv.Convert(v.Type()))
+		return nil
+	}
+	return fmt.Errorf("cannot set default value %v (%T) into field of type %s", def, def, v.Type())
+}
+
+// Read decodes into v using rs's decodePlan for v's concrete type, which
+// getPlan builds on first use and caches: one specialized decoder
+// call per field in writer field order, with reader-only fields filled
+// from their default (never read off the wire, so entry.dec is nil for
+// them and must not be invoked) instead.
+func (rs *preparedRecordSchema) Read(v interface{}, dec Decoder) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	plan, err := rs.getPlan(rv.Type())
+	if err != nil {
+		return err
+	}
+
+	for i := range plan.decodePlan {
+		entry := &plan.decodePlan[i]
+		switch entry.action {
+		case actionUseDefault:
+			// Reader-only field: never on the wire, so there is no
+			// entry.dec to call (it would be nil); fill it from the
+			// schema default instead.
+			if err := setDefault(rv.FieldByIndex(entry.index), entry.defaultValue); err != nil {
+				return fmt.Errorf("field %q: %w", entry.name, err)
+			}
+		case actionSkipWriterField:
+			// The reader dropped this field, so it has no struct
+			// field to decode into (entry.index may not even be
+			// valid); just consume its bytes off the wire.
+			if err := entry.dec(reflect.Value{}, dec); err != nil {
+				return fmt.Errorf("field %q: %w", entry.name, err)
+			}
+		default:
+			if err := entry.dec(rv.FieldByIndex(entry.index), dec); err != nil {
+				return fmt.Errorf("field %q: %w", entry.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// PreparedDatumReader reads values using a schema built by Prepare or
+// PrepareResolving, looking its decodePlan up once per reflect.Type and
+// reusing it for every subsequent Read of that type.
+type PreparedDatumReader struct {
+	schema *preparedRecordSchema
+}
+
+// NewPreparedDatumReader returns a PreparedDatumReader for a schema
+// previously returned by Prepare or PrepareResolving. It panics if
+// schema is not a prepared record schema, since there is nothing to
+// plan against otherwise.
+func NewPreparedDatumReader(schema Schema) *PreparedDatumReader {
+	rs, ok := schema.(*preparedRecordSchema)
+	if !ok {
+		panic(fmt.Sprintf("avro: PreparedDatumReader requires a schema returned by Prepare, got %T", schema))
+	}
+	return &PreparedDatumReader{schema: rs}
+}
+
+// Read decodes into v using the reader's prepared schema.
+func (r *PreparedDatumReader) Read(v interface{}, dec Decoder) error {
+	return r.schema.Read(v, dec)
+}
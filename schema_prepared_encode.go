@@ -0,0 +1,224 @@
+package avro
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// specificEncoderFunc writes one struct field's value, as planned by a
+// structFieldPlan on the encode side, into enc. It is the encode-side
+// counterpart of the function specificDecoder builds for decodePlan
+// entries.
+type specificEncoderFunc func(v reflect.Value, enc Encoder) error
+
+// specificEncoder picks a specialized encoder for entry based on its
+// concrete schema kind, so PreparedDatumWriter can write a record with a
+// tight loop over encodePlan instead of falling back to the generic
+// reflection-based writer for every field.
+func specificEncoder(entry *structFieldPlan) specificEncoderFunc {
+	switch schema := entry.schema.(type) {
+	case *preparedRecordSchema:
+		return func(v reflect.Value, enc Encoder) error {
+			return schema.Write(v.Interface(), enc)
+		}
+	case *BooleanSchema:
+		return func(v reflect.Value, enc Encoder) error {
+			return enc.WriteBoolean(v.Bool())
+		}
+	case *IntSchema:
+		return func(v reflect.Value, enc Encoder) error {
+			return enc.WriteInt(int32(v.Int()))
+		}
+	case *LongSchema:
+		return func(v reflect.Value, enc Encoder) error {
+			return enc.WriteLong(v.Int())
+		}
+	case *FloatSchema:
+		return func(v reflect.Value, enc Encoder) error {
+			return enc.WriteFloat(float32(v.Float()))
+		}
+	case *DoubleSchema:
+		return func(v reflect.Value, enc Encoder) error {
+			return enc.WriteDouble(v.Float())
+		}
+	case *StringSchema:
+		return func(v reflect.Value, enc Encoder) error {
+			return enc.WriteString(v.String())
+		}
+	case *BytesSchema:
+		return func(v reflect.Value, enc Encoder) error {
+			return enc.WriteBytes(v.Bytes())
+		}
+	case *FixedSchema:
+		return func(v reflect.Value, enc Encoder) error {
+			b := make([]byte, schema.Size)
+			reflect.Copy(reflect.ValueOf(b), v)
+			return enc.WriteFixed(b)
+		}
+	case *ArraySchema:
+		itemEnc := specificEncoder(&structFieldPlan{schema: schema.Items})
+		return func(v reflect.Value, enc Encoder) error {
+			enc.WriteArrayStart(int64(v.Len()))
+			for i := 0; i < v.Len(); i++ {
+				if err := itemEnc(v.Index(i), enc); err != nil {
+					return err
+				}
+			}
+			enc.WriteArrayNext(0)
+			return nil
+		}
+	case *MapSchema:
+		valueEnc := specificEncoder(&structFieldPlan{schema: schema.Values})
+		return func(v reflect.Value, enc Encoder) error {
+			enc.WriteMapStart(int64(v.Len()))
+			for _, key := range v.MapKeys() {
+				if err := enc.WriteString(key.String()); err != nil {
+					return err
+				}
+				if err := valueEnc(v.MapIndex(key), enc); err != nil {
+					return err
+				}
+			}
+			enc.WriteMapNext(0)
+			return nil
+		}
+	case *NullSchema:
+		return func(v reflect.Value, enc Encoder) error {
+			return nil
+		}
+	case *EnumSchema:
+		symbolIndex := make(map[string]int32, len(schema.Symbols))
+		for i, s := range schema.Symbols {
+			symbolIndex[s] = int32(i)
+		}
+		return func(v reflect.Value, enc Encoder) error {
+			idx, ok := symbolIndex[v.String()]
+			if !ok {
+				return fmt.Errorf("enum %q: unknown symbol %q", schema.Name, v.String())
+			}
+			return enc.WriteInt(idx)
+		}
+	case *RecursiveSchema:
+		// prepare's self-reference handling resolves a record field
+		// that loops back to its own (still-building) record to the
+		// *preparedRecordSchema directly, so entry.schema is normally
+		// that case above instead; this is a defensive fallback for a
+		// *RecursiveSchema that reaches here unresolved.
+		prepared, ok := Prepare(schema.Actual).(*preparedRecordSchema)
+		if !ok {
+			return func(v reflect.Value, enc Encoder) error {
+				return fmt.Errorf("field %q: cannot resolve recursive schema for encoding", entry.name)
+			}
+		}
+		return func(v reflect.Value, enc Encoder) error {
+			return prepared.Write(v.Interface(), enc)
+		}
+	case *UnionSchema:
+		return unionEncoder(schema, entry.name)
+	default:
+		return func(v reflect.Value, enc Encoder) error {
+			return fmt.Errorf("field %q: no specialized encoder for schema %T, falling back to reflection not implemented here", entry.name, entry.schema)
+		}
+	}
+}
+
+// unionEncoder builds a specificEncoderFunc for a union with exactly one
+// non-null branch (the common "nullable" field shape: ["null", T] in
+// either order). v is the struct field's Go value, conventionally a
+// pointer or other nil-able type for the null branch; a true
+// multi-branch union (more than one non-null type) has no single Go
+// type to dispatch on from a struct field alone, so that case returns an
+// encoder that always errors rather than silently writing the wrong
+// branch.
+func unionEncoder(schema *UnionSchema, name string) specificEncoderFunc {
+	nullIndex := -1
+	branchIndex := -1
+	nonNull := 0
+	var branchSchema Schema
+	for i, t := range schema.Types {
+		if _, ok := t.(*NullSchema); ok {
+			nullIndex = i
+			continue
+		}
+		nonNull++
+		branchIndex = i
+		branchSchema = t
+	}
+	if nonNull > 1 {
+		return func(v reflect.Value, enc Encoder) error {
+			return fmt.Errorf("field %q: union has %d non-null branches, only single-branch nullable unions are supported", name, nonNull)
+		}
+	}
+	if branchIndex < 0 {
+		return func(v reflect.Value, enc Encoder) error {
+			return enc.WriteInt(int32(nullIndex))
+		}
+	}
+
+	branchEnc := specificEncoder(&structFieldPlan{schema: branchSchema, name: name})
+	return func(v reflect.Value, enc Encoder) error {
+		if nullIndex >= 0 && isNilValue(v) {
+			return enc.WriteInt(int32(nullIndex))
+		}
+		if err := enc.WriteInt(int32(branchIndex)); err != nil {
+			return err
+		}
+		if v.Kind() == reflect.Ptr {
+			v = v.Elem()
+		}
+		return branchEnc(v, enc)
+	}
+}
+
+// isNilValue reports whether v holds a nil-able Go value (pointer,
+// interface, slice, or map) that is actually nil, used to pick the null
+// branch of a union field.
+func isNilValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+		return v.IsNil()
+	}
+	return false
+}
+
+// Write encodes v using rs's encodePlan for v's concrete type, which
+// getPlan builds on first use and caches: one specialized encoder call
+// per field, in schema field order.
+func (rs *preparedRecordSchema) Write(v interface{}, enc Encoder) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	plan, err := rs.getPlan(rv.Type())
+	if err != nil {
+		return err
+	}
+
+	for i := range plan.encodePlan {
+		entry := &plan.encodePlan[i]
+		if err := entry.enc(rv.FieldByIndex(entry.index), enc); err != nil {
+			return fmt.Errorf("field %q: %w", entry.name, err)
+		}
+	}
+	return nil
+}
+
+// PreparedDatumWriter writes values using a schema built by Prepare or
+// PrepareResolving, looking its encodePlan up once per reflect.Type and
+// reusing it for every subsequent Write of that type.
+type PreparedDatumWriter struct {
+	schema *preparedRecordSchema
+}
+
+// NewPreparedDatumWriter returns a PreparedDatumWriter for a schema
+// previously returned by Prepare. It panics if schema is not a prepared
+// record schema, since there is nothing to plan against otherwise.
+func NewPreparedDatumWriter(schema Schema) *PreparedDatumWriter {
+	rs, ok := schema.(*preparedRecordSchema)
+	if !ok {
+		panic(fmt.Sprintf("avro: PreparedDatumWriter requires a schema returned by Prepare, got %T", schema))
+	}
+	return &PreparedDatumWriter{schema: rs}
+}
+
+// Write encodes v into enc using the writer's prepared schema.
+func (w *PreparedDatumWriter) Write(v interface{}, enc Encoder) error {
+	return w.schema.Write(v, enc)
+}
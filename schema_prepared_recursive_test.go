@@ -0,0 +1,100 @@
+package avro
+
+import (
+	"reflect"
+	"testing"
+)
+
+// treeNode exercises a record referencing itself through an array field,
+// the {name, children: array<Node>} shape from the recursive-schema
+// request.
+type treeNode struct {
+	Name     string
+	Children []*treeNode
+}
+
+func treeNodeSchema() *RecordSchema {
+	rec := &RecordSchema{Name: "TreeNode"}
+	rec.Fields = []*SchemaField{
+		{Name: "Name", Type: &StringSchema{}},
+		{Name: "Children", Type: &ArraySchema{Items: &RecursiveSchema{Actual: rec}}},
+	}
+	return rec
+}
+
+func TestRecursiveRecordArrayRoundTrip(t *testing.T) {
+	// Prepare must not infinite-loop or stack-overflow walking a record
+	// that references itself through an array item: job.seen registers
+	// the record before recursing into its fields, so the self-reference
+	// resolves to the already-registered *preparedRecordSchema instead
+	// of recursing into prepareRecordSchema again.
+	prepared, ok := Prepare(treeNodeSchema()).(*preparedRecordSchema)
+	if !ok {
+		t.Fatalf("Prepare did not return a *preparedRecordSchema")
+	}
+
+	in := &treeNode{
+		Name: "root",
+		Children: []*treeNode{
+			{Name: "left", Children: []*treeNode{{Name: "left-left"}}},
+			{Name: "right"},
+		},
+	}
+
+	enc := NewByteBufferEncoder()
+	if err := NewPreparedDatumWriter(prepared).Write(in, enc); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	var out treeNode
+	dec := NewBinaryDecoder(enc.Bytes())
+	if err := NewPreparedDatumReader(prepared).Read(&out, dec); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+
+	if !reflect.DeepEqual(in, &out) {
+		t.Fatalf("round trip mismatch:\n  in:  %+v\n  out: %+v", in, &out)
+	}
+}
+
+// listNode exercises a record referencing itself through a nullable
+// union field, the {value, next: ["null", Node]} shape from the
+// recursive-schema request.
+type listNode struct {
+	Value int64
+	Next  *listNode
+}
+
+func listNodeSchema() *RecordSchema {
+	rec := &RecordSchema{Name: "ListNode"}
+	rec.Fields = []*SchemaField{
+		{Name: "Value", Type: &LongSchema{}},
+		{Name: "Next", Type: &UnionSchema{Types: []Schema{&NullSchema{}, &RecursiveSchema{Actual: rec}}}},
+	}
+	return rec
+}
+
+func TestRecursiveUnionLinkedListRoundTrip(t *testing.T) {
+	prepared, ok := Prepare(listNodeSchema()).(*preparedRecordSchema)
+	if !ok {
+		t.Fatalf("Prepare did not return a *preparedRecordSchema")
+	}
+
+	in := &listNode{Value: 1, Next: &listNode{Value: 2, Next: &listNode{Value: 3}}}
+
+	enc := NewByteBufferEncoder()
+	if err := NewPreparedDatumWriter(prepared).Write(in, enc); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	var out listNode
+	dec := NewBinaryDecoder(enc.Bytes())
+	if err := NewPreparedDatumReader(prepared).Read(&out, dec); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+
+	if !reflect.DeepEqual(in, &out) {
+		t.Fatalf("round trip mismatch:\n  in:  %+v -> %+v -> %+v\n  out: %+v -> %+v -> %+v",
+			in, in.Next, in.Next.Next, &out, out.Next, out.Next.Next)
+	}
+}
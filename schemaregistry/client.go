@@ -0,0 +1,135 @@
+package schemaregistry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/tunein/go-avro"
+)
+
+// contentType is the media type the Confluent Schema Registry HTTP API
+// expects and returns.
+const contentType = "application/vnd.schemaregistry.v1+json"
+
+// Client talks to a Confluent-compatible Schema Registry. The default
+// implementation wraps net/http, but callers can supply their own to add
+// auth, retries, or custom transports.
+type Client interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Registry fetches and registers Avro schemas against a Confluent Schema
+// Registry instance (e.g. the one bundled with Landoop/lensesio
+// fast-data-dev).
+type Registry struct {
+	BaseURL string
+	Client  Client
+
+	// Username and Password, when set, are sent as HTTP basic auth on
+	// every request.
+	Username string
+	Password string
+}
+
+// NewRegistry returns a Registry backed by http.DefaultClient.
+func NewRegistry(baseURL string) *Registry {
+	return &Registry{
+		BaseURL: baseURL,
+		Client:  http.DefaultClient,
+	}
+}
+
+type schemaResponse struct {
+	Schema string `json:"schema"`
+}
+
+type registerResponse struct {
+	ID int `json:"id"`
+}
+
+// GetSchema fetches the writer schema registered under the given ID.
+func (r *Registry) GetSchema(id int32) (avro.Schema, error) {
+	req, err := r.newRequest("GET", fmt.Sprintf("/schemas/ids/%d", id), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp schemaResponse
+	if err := r.do(req, &resp); err != nil {
+		return nil, fmt.Errorf("schemaregistry: fetching schema %d: %w", id, err)
+	}
+
+	schema, err := avro.ParseSchema(resp.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("schemaregistry: parsing schema %d: %w", id, err)
+	}
+	return schema, nil
+}
+
+// Register registers schema under subject and returns the ID assigned to
+// it by the registry. If an equivalent schema is already registered under
+// subject, the existing ID is returned.
+func (r *Registry) Register(subject string, schema avro.Schema) (int32, error) {
+	body, err := json.Marshal(schemaResponse{Schema: schema.String()})
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := r.newRequest("POST", fmt.Sprintf("/subjects/%s/versions", subject), bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	var resp registerResponse
+	if err := r.do(req, &resp); err != nil {
+		return 0, fmt.Errorf("schemaregistry: registering subject %s: %w", subject, err)
+	}
+	return int32(resp.ID), nil
+}
+
+func (r *Registry) newRequest(method, path string, body *bytes.Reader) (*http.Request, error) {
+	var rc *bytes.Reader
+	if body != nil {
+		rc = body
+	} else {
+		rc = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequest(method, r.BaseURL+path, rc)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", contentType)
+	if r.Username != "" || r.Password != "" {
+		req.SetBasicAuth(r.Username, r.Password)
+	}
+	return req, nil
+}
+
+func (r *Registry) do(req *http.Request, out interface{}) error {
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("registry returned %s: %s", resp.Status, raw)
+	}
+
+	return json.Unmarshal(raw, out)
+}
@@ -0,0 +1,51 @@
+package schemaregistry
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/tunein/go-avro"
+)
+
+type widget struct {
+	Name string
+}
+
+func TestRegistryCodecRoundTrip(t *testing.T) {
+	mock := NewMockRegistry()
+	defer mock.Close()
+
+	schema, err := avro.ParseSchema(`{"type":"record","name":"Widget","fields":[{"name":"Name","type":"string"}]}`)
+	if err != nil {
+		t.Fatalf("ParseSchema: %v", err)
+	}
+
+	codec := NewRegistryCodec(NewRegistry(mock.URL()), schema)
+
+	data, err := codec.Encode("widgets-value", &widget{Name: "gizmo"})
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	// The Confluent wire format is magic byte + 4 raw big-endian bytes,
+	// not an Avro "bytes" value (which would insert a zig-zag length
+	// prefix before the ID); assert the header is exactly wireHeaderLen
+	// bytes and that those 4 bytes decode straight to the registered ID.
+	if data[0] != magicByte {
+		t.Fatalf("magic byte = 0x%02x, want 0x%02x", data[0], magicByte)
+	}
+	if len(data) < wireHeaderLen {
+		t.Fatalf("frame too short: got %d bytes, want at least %d", len(data), wireHeaderLen)
+	}
+	if id := binary.BigEndian.Uint32(data[1:wireHeaderLen]); id != 1 {
+		t.Fatalf("schema id = %d, want 1 (first schema registered)", id)
+	}
+
+	var out widget
+	if err := codec.Decode(data, &out); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if out.Name != "gizmo" {
+		t.Fatalf("Decode got %+v, want Name=gizmo", out)
+	}
+}
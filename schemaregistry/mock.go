@@ -0,0 +1,99 @@
+package schemaregistry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// MockRegistry is an in-memory stand-in for a Confluent Schema Registry,
+// backed by an httptest.Server, for use in tests that exercise
+// RegistryCodec without a real registry running.
+type MockRegistry struct {
+	server *httptest.Server
+
+	mu      sync.Mutex
+	nextID  int
+	schemas map[int]string
+	byID    map[string]int
+}
+
+// NewMockRegistry starts a mock registry server. Callers must Close it
+// when done.
+func NewMockRegistry() *MockRegistry {
+	m := &MockRegistry{
+		nextID:  1,
+		schemas: make(map[int]string),
+		byID:    make(map[string]int),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/schemas/ids/", m.handleGetSchema)
+	mux.HandleFunc("/subjects/", m.handleRegister)
+	m.server = httptest.NewServer(mux)
+	return m
+}
+
+// URL returns the base URL of the mock server, suitable for Registry.BaseURL.
+func (m *MockRegistry) URL() string {
+	return m.server.URL
+}
+
+// Close shuts down the underlying test server.
+func (m *MockRegistry) Close() {
+	m.server.Close()
+}
+
+func (m *MockRegistry) handleGetSchema(w http.ResponseWriter, r *http.Request) {
+	var id int
+	if _, err := fmt.Sscanf(r.URL.Path, "/schemas/ids/%d", &id); err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	raw, ok := m.schemas[id]
+	m.mu.Unlock()
+	if !ok {
+		http.Error(w, "schema not found", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, schemaResponse{Schema: raw})
+}
+
+func (m *MockRegistry) handleRegister(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req schemaResponse
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	id, ok := m.byID[req.Schema]
+	if !ok {
+		id = m.nextID
+		m.nextID++
+		m.byID[req.Schema] = id
+		m.schemas[id] = req.Schema
+	}
+	m.mu.Unlock()
+
+	writeJSON(w, registerResponse{ID: id})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	var buf bytes.Buffer
+	_ = json.NewEncoder(&buf).Encode(v)
+	w.Header().Set("Content-Type", contentType)
+	_, _ = w.Write(buf.Bytes())
+}